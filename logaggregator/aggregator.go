@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"net/http"
 	"sync"
 
 	"github.com/flynn/flynn/logaggregator/ring"
+	"github.com/flynn/flynn/logaggregator/sinks"
 	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
 )
 
 var errBufferFull = errors.New("feed buffer full")
@@ -19,15 +25,21 @@ type Aggregator struct {
 
 	pmu    sync.Mutex
 	pausec chan struct{}
+
+	sinkWorkers []*sinkWorker
 }
 
 // NewAggregator creates a new unstarted Aggregator that will listen on addr.
-func NewAggregator() *Aggregator {
+// Every fed message is also fanned out to each of the given sinks.
+func NewAggregator(sinkList []sinks.Sink) *Aggregator {
 	a := &Aggregator{
 		buffers: make(map[string]*ring.Buffer),
 		msgc:    make(chan *rfc5424.Message, 1000),
 		pausec:  make(chan struct{}),
 	}
+	for _, sink := range sinkList {
+		a.sinkWorkers = append(a.sinkWorkers, newSinkWorker(sink))
+	}
 	go a.run()
 	return a
 }
@@ -40,13 +52,13 @@ func (a *Aggregator) Shutdown() {
 
 // ReadLastN reads up to N logs from the log buffer with id and sends them over
 // a channel. If n is less than 0, or if there are fewer than n logs buffered,
-// all buffered logs are returned. If a signal is sent on done, the returned
-// channel is closed and the goroutine exits.
+// all buffered logs are returned. If ctx is done, the returned channel is
+// closed and the goroutine exits.
 func (a *Aggregator) ReadLastN(
+	ctx context.Context,
 	id string,
 	n int,
 	filters []filter,
-	done <-chan struct{},
 ) <-chan *rfc5424.Message {
 	msgc := make(chan *rfc5424.Message)
 	go func() {
@@ -64,7 +76,7 @@ func (a *Aggregator) ReadLastN(
 		for _, syslogMsg := range messages {
 			select {
 			case msgc <- syslogMsg:
-			case <-done:
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -89,10 +101,10 @@ func (a *Aggregator) readLastN(id string, n int) []*rfc5424.Message {
 // ReadLastNAndSubscribe is like ReadLastN, except that after sending buffered
 // log lines, it also streams new lines as they arrive.
 func (a *Aggregator) ReadLastNAndSubscribe(
+	ctx context.Context,
 	id string,
 	n int,
 	filters []filter,
-	done <-chan struct{},
 ) <-chan *rfc5424.Message {
 	msgc := make(chan *rfc5424.Message)
 	go func() {
@@ -116,16 +128,16 @@ func (a *Aggregator) ReadLastNAndSubscribe(
 		defer cancel()
 		defer close(msgc)
 
-		// range over messages, watch done
+		// range over messages, watch ctx
 		for _, msg := range messages {
 			select {
-			case <-done:
+			case <-ctx.Done():
 				return
 			case msgc <- msg:
 			}
 		}
 
-		// select on subc, done, and cancel if done
+		// select on subc, ctx.Done, and cancel if done
 		for {
 			select {
 			case msg := <-subc:
@@ -137,10 +149,10 @@ func (a *Aggregator) ReadLastNAndSubscribe(
 				}
 				select {
 				case msgc <- msg:
-				case <-done:
+				case <-ctx.Done():
 					return
 				}
-			case <-done:
+			case <-ctx.Done():
 				return
 			}
 		}
@@ -184,6 +196,48 @@ func (a *Aggregator) CopyBuffers() [][]*rfc5424.Message {
 	return buffers
 }
 
+// SinkDrops returns, for each configured sink in registration order, the
+// number of messages dropped because its queue was full.
+func (a *Aggregator) SinkDrops() []uint64 {
+	drops := make([]uint64, len(a.sinkWorkers))
+	for i, w := range a.sinkWorkers {
+		drops[i] = w.Drops()
+	}
+	return drops
+}
+
+// SinkErrors returns, for each configured sink in registration order, the
+// number of Write calls that have returned an error.
+func (a *Aggregator) SinkErrors() []uint64 {
+	errs := make([]uint64, len(a.sinkWorkers))
+	for i, w := range a.sinkWorkers {
+		errs[i] = w.Errors()
+	}
+	return errs
+}
+
+// sinkStat reports one configured sink's error metrics in sinkStatsHandler's
+// JSON body.
+type sinkStat struct {
+	Drops  uint64 `json:"drops"`
+	Errors uint64 `json:"errors"`
+}
+
+// sinkStatsHandler serves a's per-sink drop and write-error counts as JSON,
+// in registration order.
+func sinkStatsHandler(a *Aggregator) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		drops, errs := a.SinkDrops(), a.SinkErrors()
+		stats := make([]sinkStat, len(drops))
+		for i := range stats {
+			stats[i] = sinkStat{Drops: drops[i], Errors: errs[i]}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
 // testing hook:
 var afterMessage func()
 
@@ -212,10 +266,18 @@ func (a *Aggregator) run() {
 		select {
 		case msg, ok := <-a.msgc:
 			if !ok {
+				for _, w := range a.sinkWorkers {
+					if err := w.close(); err != nil {
+						log15.Error("sink close error", "err", err)
+					}
+				}
 				return
 			}
 
 			a.getOrInitializeBuffer(string(msg.AppName)).Add(msg)
+			for _, w := range a.sinkWorkers {
+				w.feed(msg)
+			}
 			if afterMessage != nil {
 				afterMessage()
 			}
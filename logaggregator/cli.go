@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/flynn/flynn/logaggregator/sinks"
+)
+
+// BootstrapConfig is the set of peers to join to a Server's Raft cluster on
+// startup, as parsed from CLI flags.
+type BootstrapConfig struct {
+	Join []RaftPeer
+}
+
+// ParseBootstrapFlags parses repeated -join flags of the form
+// "syslogAddr,raftAddr" into a BootstrapConfig. It's the CLI entry point for
+// bootstrapping or joining a Raft cluster: pass the result to
+// Server.Bootstrap once the server's listeners are up.
+func ParseBootstrapFlags(fs *flag.FlagSet, args []string) (BootstrapConfig, error) {
+	var joins joinFlag
+	fs.Var(&joins, "join", "syslogAddr,raftAddr of a peer to join (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return BootstrapConfig{}, err
+	}
+	return BootstrapConfig{Join: []RaftPeer(joins)}, nil
+}
+
+// Bootstrap joins every peer in conf.Join to s's Raft cluster. It must be
+// called against the current leader, typically the first node started in a
+// new cluster, with one -join flag for each other node to add. On a node
+// that is not the leader, it returns the error from adding the first peer.
+func (s *Server) Bootstrap(conf BootstrapConfig) error {
+	if len(conf.Join) == 0 {
+		return nil
+	}
+	return s.JoinRaftCluster(conf.Join)
+}
+
+// joinFlag accumulates repeated -join flag values into RaftPeers.
+type joinFlag []RaftPeer
+
+func (j *joinFlag) String() string {
+	return fmt.Sprint([]RaftPeer(*j))
+}
+
+func (j *joinFlag) Set(value string) error {
+	parts := strings.SplitN(value, ",", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return errors.New("join flag must be of the form syslogAddr,raftAddr")
+	}
+	*j = append(*j, RaftPeer{SyslogAddr: parts[0], RaftAddr: parts[1]})
+	return nil
+}
+
+// ParseSinkFlags parses flag-driven sink configuration - -sink-console, and
+// repeatable -sink-file=path and -sink-http=url - into the sinks.Sink list a
+// ServerConfig.Sinks field expects.
+func ParseSinkFlags(fs *flag.FlagSet, args []string) ([]sinks.Sink, error) {
+	var console bool
+	var files stringListFlag
+	var httpURLs stringListFlag
+
+	fs.BoolVar(&console, "sink-console", false, "write ingested messages to stdout")
+	fs.Var(&files, "sink-file", "path of a file to write ingested messages to (repeatable)")
+	fs.Var(&httpURLs, "sink-http", "URL to POST batches of ingested messages to as JSON (repeatable)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var sinkList []sinks.Sink
+	if console {
+		sinkList = append(sinkList, sinks.NewStdoutSink())
+	}
+	for _, path := range files {
+		sinkList = append(sinkList, sinks.NewFileSink(sinks.FileConfig{Path: path}))
+	}
+	for _, url := range httpURLs {
+		sinkList = append(sinkList, sinks.NewHTTPSink(sinks.HTTPConfig{URL: url}))
+	}
+	return sinkList, nil
+}
+
+// stringListFlag accumulates repeated flag values verbatim.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return fmt.Sprint([]string(*f))
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
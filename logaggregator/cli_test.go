@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/flynn/flynn/logaggregator/sinks"
+)
+
+func TestParseSinkFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	got, err := ParseSinkFlags(fs, []string{
+		"-sink-console",
+		"-sink-file", "/var/log/app.log",
+		"-sink-http", "http://example.com/logs",
+		"-sink-http", "http://example.com/logs2",
+	})
+	if err != nil {
+		t.Fatalf("ParseSinkFlags: %v", err)
+	}
+
+	want := []string{"*sinks.ConsoleSink", "*sinks.FileSink", "*sinks.HTTPSink", "*sinks.HTTPSink"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d sinks, want %d", len(got), len(want))
+	}
+	for i, sink := range got {
+		typeName := sinkTypeName(sink)
+		if typeName != want[i] {
+			t.Errorf("sink %d has type %s, want %s", i, typeName, want[i])
+		}
+	}
+}
+
+func TestParseSinkFlagsNoneConfigured(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	got, err := ParseSinkFlags(fs, nil)
+	if err != nil {
+		t.Fatalf("ParseSinkFlags: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("got %d sinks, want 0", len(got))
+	}
+}
+
+func TestParseBootstrapFlagsRejectsMalformedJoin(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(nopWriter{})
+	if _, err := ParseBootstrapFlags(fs, []string{"-join", "not-a-valid-pair"}); err == nil {
+		t.Fatal("ParseBootstrapFlags returned nil error, want an error")
+	}
+}
+
+func TestParseBootstrapFlags(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	conf, err := ParseBootstrapFlags(fs, []string{"-join", "10.0.0.1:1,10.0.0.1:2"})
+	if err != nil {
+		t.Fatalf("ParseBootstrapFlags: %v", err)
+	}
+	want := []RaftPeer{{SyslogAddr: "10.0.0.1:1", RaftAddr: "10.0.0.1:2"}}
+	if len(conf.Join) != 1 || conf.Join[0] != want[0] {
+		t.Fatalf("Join = %v, want %v", conf.Join, want)
+	}
+}
+
+// sinkTypeName returns a sinks.Sink's concrete type, for asserting which
+// sink ParseSinkFlags constructed without reaching into its fields.
+func sinkTypeName(s sinks.Sink) string {
+	switch s.(type) {
+	case *sinks.ConsoleSink:
+		return "*sinks.ConsoleSink"
+	case *sinks.FileSink:
+		return "*sinks.FileSink"
+	case *sinks.HTTPSink:
+		return "*sinks.HTTPSink"
+	default:
+		return "unknown"
+	}
+}
+
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) { return len(p), nil }
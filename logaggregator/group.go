@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
+)
+
+// restartBackoff is paused before a restartable member is run again, so a
+// member stuck in a tight failure loop doesn't spin.
+const restartBackoff = time.Second
+
+// member is a single named, independently supervised component of
+// Server.Run. If restartOn is nil, any error from run aborts the whole
+// group; otherwise restartOn decides whether run is invoked again instead.
+type member struct {
+	name      string
+	run       func(ctx context.Context) error
+	restartOn func(err error) bool
+}
+
+// runMember runs m.run until it returns nil, ctx is done, or it returns a
+// non-restartable error.
+func runMember(ctx context.Context, m member) error {
+	for {
+		err := m.run(ctx)
+		if err == nil || ctx.Err() != nil || m.restartOn == nil || !m.restartOn(err) {
+			return err
+		}
+		log15.Error("member restarting after error", "member", m.name, "err", err)
+		time.Sleep(restartBackoff)
+	}
+}
+
+// waitForSignal blocks until a SIGINT or SIGTERM is received, returning it,
+// or until ctx is done, returning nil.
+func waitForSignal(ctx context.Context) os.Signal {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+
+	select {
+	case sig := <-sigc:
+		return sig
+	case <-ctx.Done():
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunMemberRestartsOnRestartableError(t *testing.T) {
+	errTransient := errors.New("transient")
+
+	var calls int
+	m := member{
+		name: "test",
+		run: func(ctx context.Context) error {
+			calls++
+			if calls < 3 {
+				return errTransient
+			}
+			return nil
+		},
+		restartOn: func(err error) bool { return err == errTransient },
+	}
+
+	if err := runMember(context.Background(), m); err != nil {
+		t.Fatalf("runMember: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("run called %d times, want 3", calls)
+	}
+}
+
+func TestRunMemberAbortsOnNonRestartableError(t *testing.T) {
+	errFatal := errors.New("fatal")
+
+	var calls int
+	m := member{
+		name: "test",
+		run: func(ctx context.Context) error {
+			calls++
+			return errFatal
+		},
+		restartOn: func(err error) bool { return false },
+	}
+
+	err := runMember(context.Background(), m)
+	if err != errFatal {
+		t.Fatalf("runMember returned %v, want %v", err, errFatal)
+	}
+	if calls != 1 {
+		t.Fatalf("run called %d times, want 1", calls)
+	}
+}
+
+func TestRunMemberStopsOnContextDone(t *testing.T) {
+	errTransient := errors.New("transient")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var calls int
+	m := member{
+		name: "test",
+		run: func(ctx context.Context) error {
+			calls++
+			return errTransient
+		},
+		restartOn: func(err error) bool { return true },
+	}
+
+	err := runMember(ctx, m)
+	if err != errTransient {
+		t.Fatalf("runMember returned %v, want %v", err, errTransient)
+	}
+	if calls != 1 {
+		t.Fatalf("run called %d times, want 1 (ctx already done)", calls)
+	}
+}
+
+func TestWaitForSignalReturnsOnContextDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if sig := waitForSignal(ctx); sig != nil {
+		t.Fatalf("waitForSignal returned %v, want nil", sig)
+	}
+}
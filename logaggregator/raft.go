@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/flynn/flynn/logaggregator/snapshot"
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/hashicorp/raft"
+	raftboltdb "github.com/flynn/flynn/Godeps/_workspace/src/github.com/hashicorp/raft-boltdb"
+)
+
+// RaftConfig configures the Raft node backing a Server's Replicator.
+type RaftConfig struct {
+	// Dir holds the Raft log, stable store, and snapshots.
+	Dir string
+	// Advertise is this node's raft transport address, as seen by its
+	// peers.
+	Advertise string
+	// SyslogAddr is this node's syslog listener address. It's used as
+	// this node's raft.ServerID, so that a non-leader peer can look up
+	// where to forward syslog ingest.
+	SyslogAddr string
+	// Bootstrap starts a brand new single-node cluster rooted at this
+	// node; it must be set on exactly one node when first forming a
+	// cluster, and left unset when joining an existing one.
+	Bootstrap bool
+}
+
+// newRaftNode starts a Raft node whose transport is layered over listener -
+// the same TCP listener the server already binds for replication - and
+// whose FSM applies committed entries to agg.
+func newRaftNode(conf RaftConfig, agg *Aggregator, listener net.Listener) (*raft.Raft, *raft.NetworkTransport, error) {
+	if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	store, err := raftboltdb.NewBoltStore(filepath.Join(conf.Dir, "raft.db"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(conf.Dir, 2, os.Stderr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	advertise, err := net.ResolveTCPAddr("tcp", conf.Advertise)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport := raft.NewNetworkTransport(&raftStreamLayer{Listener: listener, advertise: advertise}, 3, 10*time.Second, os.Stderr)
+
+	raftConf := raft.DefaultConfig()
+	// LocalID is this node's raft.ServerID, matching the syslog address
+	// used for it everywhere else (Bootstrap, AddVoter, LeaderSyslogAddr).
+	raftConf.LocalID = raft.ServerID(conf.SyslogAddr)
+
+	r, err := raft.NewRaft(raftConf, newRaftFSM(agg), store, store, snapshots, transport)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if conf.Bootstrap {
+		f := r.BootstrapCluster(raft.Configuration{
+			Servers: []raft.Server{{ID: raft.ServerID(conf.SyslogAddr), Address: transport.LocalAddr()}},
+		})
+		if err := f.Error(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return r, transport, nil
+}
+
+// raftStreamLayer adapts the already-bound replication listener to
+// raft.StreamLayer, so the Raft transport reuses it instead of binding its
+// own socket.
+type raftStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+}
+
+func (l *raftStreamLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", string(addr), timeout)
+}
+
+func (l *raftStreamLayer) Addr() net.Addr {
+	return l.advertise
+}
+
+// raftFSM applies committed Raft log entries - each a single rfc5424-framed
+// syslog message - to an Aggregator.
+type raftFSM struct {
+	agg *Aggregator
+}
+
+func newRaftFSM(agg *Aggregator) *raftFSM {
+	return &raftFSM{agg: agg}
+}
+
+func (f *raftFSM) Apply(l *raft.Log) interface{} {
+	msg, err := rfc5424.Parse(l.Data)
+	if err != nil {
+		return err
+	}
+	f.agg.Feed(msg)
+	return nil
+}
+
+// Snapshot serializes the Aggregator's buffers using the existing snapshot
+// format, so Raft can ship a compact state transfer to lagging peers.
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return &raftSnapshot{buffers: f.agg.CopyBuffers()}, nil
+}
+
+// Restore replaces the Aggregator's buffers with the contents of a snapshot
+// produced by Snapshot.
+func (f *raftFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	f.agg.Flush()
+	sc := snapshot.NewScanner(rc)
+	for sc.Scan() {
+		f.agg.Feed(sc.Message)
+	}
+	return sc.Err()
+}
+
+type raftSnapshot struct {
+	buffers [][]*rfc5424.Message
+}
+
+func (s *raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := snapshot.WriteTo(s.buffers, sink); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *raftSnapshot) Release() {}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/hashicorp/raft"
+)
+
+// TestNewRaftNodeBootstrapAndApply exercises newRaftNode itself (rather than
+// raftFSM directly): a single bootstrapped node should elect itself leader
+// and apply entries to its Aggregator. This is the path that previously
+// failed unconditionally for lack of Config.LocalID.
+func TestNewRaftNodeBootstrapAndApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft-node-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	agg := NewAggregator(nil)
+	defer agg.Shutdown()
+
+	conf := RaftConfig{
+		Dir:        dir,
+		Advertise:  ln.Addr().String(),
+		SyslogAddr: "127.0.0.1:9999",
+		Bootstrap:  true,
+	}
+
+	r, transport, err := newRaftNode(conf, agg, ln)
+	if err != nil {
+		t.Fatalf("newRaftNode: %v", err)
+	}
+	defer func() {
+		if err := r.Shutdown().Error(); err != nil {
+			t.Errorf("raft Shutdown: %v", err)
+		}
+		transport.Close()
+	}()
+
+	select {
+	case <-r.LeaderCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for single-node cluster to elect a leader")
+	}
+	if r.State() != raft.Leader {
+		t.Fatalf("State() = %v, want Leader", r.State())
+	}
+
+	msg := testMessage(t)
+	waitIndexed(t, agg, func() {
+		if err := r.Apply([]byte(msg.String()), applyTimeout).Error(); err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+	})
+
+	got := readAll(t, agg, string(msg.AppName))
+	if len(got) != 1 || got[0].String() != msg.String() {
+		t.Fatalf("buffer contains %v, want [%v]", got, msg)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/hashicorp/raft"
+)
+
+// bufSnapshotSink adapts a bytes.Buffer to raft.SnapshotSink, so
+// raftSnapshot.Persist can be exercised without a real raft.FileSnapshotStore.
+type bufSnapshotSink struct {
+	bytes.Buffer
+	canceled bool
+}
+
+func (s *bufSnapshotSink) ID() string    { return "test" }
+func (s *bufSnapshotSink) Close() error  { return nil }
+func (s *bufSnapshotSink) Cancel() error { s.canceled = true; return nil }
+
+func readAll(t *testing.T, agg *Aggregator, appName string) []*rfc5424.Message {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var got []*rfc5424.Message
+	for msg := range agg.ReadLastN(ctx, appName, -1, nil) {
+		got = append(got, msg)
+	}
+	return got
+}
+
+// waitIndexed blocks until agg's run() goroutine has finished indexing the
+// next message fed to it, using the afterMessage test hook rather than
+// relying on goroutine-scheduling luck.
+func waitIndexed(t *testing.T, agg *Aggregator, fn func()) {
+	t.Helper()
+
+	donec := make(chan struct{})
+	afterMessage = func() { close(donec) }
+	defer func() { afterMessage = nil }()
+
+	fn()
+
+	select {
+	case <-donec:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message to be indexed")
+	}
+}
+
+func TestRaftFSMApply(t *testing.T) {
+	agg := NewAggregator(nil)
+	defer agg.Shutdown()
+
+	fsm := newRaftFSM(agg)
+	msg := testMessage(t)
+
+	waitIndexed(t, agg, func() {
+		if res := fsm.Apply(&raft.Log{Data: []byte(msg.String())}); res != nil {
+			t.Fatalf("Apply returned %v, want nil", res)
+		}
+	})
+
+	got := readAll(t, agg, string(msg.AppName))
+	if len(got) != 1 || got[0].String() != msg.String() {
+		t.Fatalf("buffer contains %v, want [%v]", got, msg)
+	}
+}
+
+func TestRaftFSMApplyInvalidMessage(t *testing.T) {
+	agg := NewAggregator(nil)
+	defer agg.Shutdown()
+
+	fsm := newRaftFSM(agg)
+
+	res := fsm.Apply(&raft.Log{Data: []byte("not a valid rfc5424 message")})
+	if res == nil {
+		t.Fatal("Apply returned nil, want a parse error")
+	}
+	if _, ok := res.(error); !ok {
+		t.Fatalf("Apply returned %T, want error", res)
+	}
+}
+
+func TestRaftFSMSnapshotAndRestore(t *testing.T) {
+	src := NewAggregator(nil)
+	defer src.Shutdown()
+
+	fsm := newRaftFSM(src)
+	msg := testMessage(t)
+	waitIndexed(t, src, func() {
+		if res := fsm.Apply(&raft.Log{Data: []byte(msg.String())}); res != nil {
+			t.Fatalf("Apply: %v", res)
+		}
+	})
+
+	snap, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	sink := &bufSnapshotSink{}
+	if err := snap.Persist(sink); err != nil {
+		t.Fatalf("Persist: %v", err)
+	}
+	if sink.canceled {
+		t.Fatal("Persist canceled the sink")
+	}
+	snap.Release()
+
+	dst := NewAggregator(nil)
+	defer dst.Shutdown()
+
+	dstFSM := newRaftFSM(dst)
+	waitIndexed(t, dst, func() {
+		if err := dstFSM.Restore(&nopReadCloser{&sink.Buffer}); err != nil {
+			t.Fatalf("Restore: %v", err)
+		}
+	})
+
+	got := readAll(t, dst, string(msg.AppName))
+	if len(got) != 1 || got[0].String() != msg.String() {
+		t.Fatalf("restored buffer contains %v, want [%v]", got, msg)
+	}
+}
+
+type nopReadCloser struct {
+	*bytes.Buffer
+}
+
+func (nopReadCloser) Close() error { return nil }
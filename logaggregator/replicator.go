@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/github.com/hashicorp/raft"
+	"github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
+)
+
+// applyTimeout bounds how long a proposed message is allowed to sit
+// uncommitted before Replicator.Feed gives up on it.
+const applyTimeout = 10 * time.Second
+
+// forwardTimeout bounds dialing & writing a message forwarded to the
+// leader's syslog listener.
+const forwardTimeout = 5 * time.Second
+
+var (
+	errNoLeader          = errors.New("replicator: no raft leader elected")
+	errUnknownLeaderAddr = errors.New("replicator: leader syslog address not found in raft configuration")
+)
+
+// Replicator adapts the Aggregator to a Raft-replicated log: messages fed to
+// it are proposed as Raft log entries, and only reach every peer's
+// Aggregator.Feed once committed, via raftFSM.Apply. Each raft.ServerID is
+// the syslog address of the node it identifies, so that a non-leader can
+// forward ingest to whichever node is currently leader.
+type Replicator struct {
+	raft      *raft.Raft
+	transport *raft.NetworkTransport
+}
+
+// NewReplicator wraps a started Raft node.
+func NewReplicator(r *raft.Raft, transport *raft.NetworkTransport) *Replicator {
+	return &Replicator{raft: r, transport: transport}
+}
+
+// Feed proposes data - a single rfc5424-framed syslog message - as a Raft
+// log entry if this node is the leader, forwarding it to the leader's
+// syslog listener otherwise. It returns once the message has been proposed
+// or forwarded; commit errors are logged asynchronously rather than
+// blocking the caller on a quorum round-trip per message.
+func (r *Replicator) Feed(data []byte) error {
+	if r.raft.State() != raft.Leader {
+		return r.forward(data)
+	}
+
+	future := r.raft.Apply(data, applyTimeout)
+	go func() {
+		if err := future.Error(); err != nil {
+			log15.Error("raft apply error", "err", err)
+		}
+	}()
+	return nil
+}
+
+// forward dials the current leader's syslog listener and writes data to it
+// octet-counting-framed (RFC 6587), exactly as an external syslog client
+// would. data is already de-framed rfc5424 bytes - drainSyslogConn's
+// rfc6587.Split on the receiving end expects the wire framing that was
+// stripped by this node's own accept loop, not the bare message.
+func (r *Replicator) forward(data []byte) error {
+	addr, err := r.LeaderSyslogAddr()
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, forwardTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(forwardTimeout))
+	if _, err := fmt.Fprintf(conn, "%d ", len(data)); err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+// LeaderSyslogAddr returns the syslog listener address of the current raft
+// leader, looked up by matching its transport address against the cluster
+// configuration.
+func (r *Replicator) LeaderSyslogAddr() (string, error) {
+	leaderAddr := r.raft.Leader()
+	if leaderAddr == "" {
+		return "", errNoLeader
+	}
+
+	cf := r.raft.GetConfiguration()
+	if err := cf.Error(); err != nil {
+		return "", err
+	}
+	for _, srv := range cf.Configuration().Servers {
+		if srv.Address == leaderAddr {
+			return string(srv.ID), nil
+		}
+	}
+	return "", errUnknownLeaderAddr
+}
+
+// AddVoter adds a node, identified by its syslog address syslogAddr and
+// raft transport address raftAddr, as a voting member of the Raft cluster.
+// It must be called against the current leader.
+func (r *Replicator) AddVoter(syslogAddr, raftAddr string) error {
+	return r.raft.AddVoter(raft.ServerID(syslogAddr), raft.ServerAddress(raftAddr), 0, 0).Error()
+}
+
+// Leader reports whether this node is the current Raft leader.
+func (r *Replicator) Leader() bool {
+	return r.raft.State() == raft.Leader
+}
+
+// CommitIndex returns the index of the last Raft log entry applied to this
+// node's Aggregator.
+func (r *Replicator) CommitIndex() uint64 {
+	return r.raft.AppliedIndex()
+}
+
+// Shutdown shuts down the underlying Raft node and its transport.
+func (r *Replicator) Shutdown() error {
+	if err := r.raft.Shutdown().Error(); err != nil {
+		return err
+	}
+	return r.transport.Close()
+}
+
+// raftStatus is the JSON body served by raftStatusHandler.
+type raftStatus struct {
+	Leader      bool   `json:"leader"`
+	LeaderAddr  string `json:"leader_addr,omitempty"`
+	CommitIndex uint64 `json:"commit_index"`
+}
+
+// raftStatusHandler serves r's leadership and commit-index as JSON, for
+// observability into the replicated log without a raft client.
+func raftStatusHandler(r *Replicator) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		status := raftStatus{
+			Leader:      r.Leader(),
+			CommitIndex: r.CommitIndex(),
+		}
+		if addr, err := r.LeaderSyslogAddr(); err == nil {
+			status.LeaderAddr = addr
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
@@ -2,18 +2,19 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"net"
 	"net/http"
 	"os"
 	"sync"
 
 	"github.com/flynn/flynn/discoverd/client"
+	"github.com/flynn/flynn/logaggregator/sinks"
 	"github.com/flynn/flynn/logaggregator/snapshot"
-	"github.com/flynn/flynn/pkg/connutil"
-	"github.com/flynn/flynn/pkg/stream"
 	"github.com/flynn/flynn/pkg/syslog/rfc5424"
 	"github.com/flynn/flynn/pkg/syslog/rfc6587"
 
+	"github.com/flynn/flynn/Godeps/_workspace/src/golang.org/x/sync/errgroup"
 	"github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
 )
 
@@ -21,17 +22,14 @@ type Server struct {
 	*Aggregator
 	*Replicator
 
-	ll, rl, al net.Listener   // syslog, replication, and api listeners
-	lwg, rwg   sync.WaitGroup // syslog & replication wait groups
+	ll, al      net.Listener // syslog & api listeners
+	lwg         sync.WaitGroup
+	serviceName string
 
-	discd  *discoverd.Client
-	hb     discoverd.Heartbeater
-	srv    discoverd.Service
-	stream stream.Stream
-	eventc <-chan *discoverd.Event
+	discd *discoverd.Client
+	hb    discoverd.Heartbeater
 
-	api      http.Handler
-	shutdown chan struct{}
+	api http.Handler
 }
 
 type ServerConfig struct {
@@ -39,6 +37,14 @@ type ServerConfig struct {
 
 	serviceName string
 	discoverd   *discoverd.Client
+
+	// Raft configures the replicated log used to fan syslog messages out
+	// to every peer.
+	Raft RaftConfig
+
+	// Sinks are fed every message ingested by the Aggregator, in addition
+	// to the in-memory ring buffers.
+	Sinks []sinks.Sink
 }
 
 func NewServer(conf ServerConfig) (*Server, error) {
@@ -47,6 +53,9 @@ func NewServer(conf ServerConfig) (*Server, error) {
 		return nil, err
 	}
 
+	// rl is bound here, ahead of the raft node, so that a port conflict
+	// is reported to the caller before the server registers with
+	// discoverd.
 	rl, err := net.Listen("tcp", conf.replicationAddr)
 	if err != nil {
 		return nil, err
@@ -57,68 +66,51 @@ func NewServer(conf ServerConfig) (*Server, error) {
 		return nil, err
 	}
 
-	eventc := make(chan *discoverd.Event)
-	srv := conf.discoverd.Service(conf.serviceName)
-	stream, err := srv.Watch(eventc)
-	if err != nil {
-		return nil, err
-	}
+	a := NewAggregator(conf.Sinks)
 
-	hb, err := conf.discoverd.AddServiceAndRegister(conf.serviceName, ll.Addr().String())
+	conf.Raft.SyslogAddr = ll.Addr().String()
+	r, transport, err := newRaftNode(conf.Raft, a, rl)
 	if err != nil {
 		return nil, err
 	}
+	replicator := NewReplicator(r, transport)
 
-	a := NewAggregator()
+	mux := http.NewServeMux()
+	mux.Handle("/raft/status", raftStatusHandler(replicator))
+	mux.Handle("/sinks/stats", sinkStatsHandler(a))
+	mux.Handle("/", apiHandler(a))
 
 	return &Server{
 		Aggregator: a,
-		Replicator: NewReplicator(),
+		Replicator: replicator,
 
-		ll: ll,
-		rl: rl,
-		al: al,
+		ll:          ll,
+		al:          al,
+		serviceName: conf.serviceName,
 
-		discd:  conf.discoverd,
-		hb:     hb,
-		srv:    srv,
-		stream: stream,
-		eventc: eventc,
+		discd: conf.discoverd,
 
-		api:      apiHandler(a),
-		shutdown: make(chan struct{}),
+		api: mux,
 	}, nil
 }
 
-func (s *Server) Shutdown() {
-	if err := s.stream.Close(); err != nil {
-		log15.Error("event stream shutdown error", "err", err)
-	}
-
-	// close discoverd service heartbeater
-	if err := s.hb.Close(); err != nil {
-		log15.Error("heartbeat shutdown error", "err", err)
-	}
+// RaftPeer identifies a node being joined to the Raft cluster.
+type RaftPeer struct {
+	// SyslogAddr is the peer's syslog listener address.
+	SyslogAddr string
+	// RaftAddr is the peer's raft transport (advertise) address.
+	RaftAddr string
+}
 
-	// shutdown listeners
-	if err := s.ll.Close(); err != nil {
-		log15.Error("syslog listener shutdown error", "err", err)
-	}
-	if err := s.rl.Close(); err != nil {
-		log15.Error("replication listener shutdown error", "err", err)
-	}
-	if err := s.al.Close(); err != nil {
-		log15.Error("api listener shutdown error", "err", err)
+// JoinRaftCluster adds peers as voting members of the Raft cluster backing
+// this server. It must be called against the current leader.
+func (s *Server) JoinRaftCluster(peers []RaftPeer) error {
+	for _, peer := range peers {
+		if err := s.Replicator.AddVoter(peer.SyslogAddr, peer.RaftAddr); err != nil {
+			return err
+		}
 	}
-
-	// close syslog & replication client connections
-	close(s.shutdown)
-	s.lwg.Wait()
-
-	// shutdown aggregator & replicator
-	s.Aggregator.Shutdown()
-	s.Replicator.Shutdown()
-	s.rwg.Wait()
+	return nil
 }
 
 func (s *Server) LoadSnapshot(path string) error {
@@ -152,30 +144,138 @@ func (s *Server) SyslogAddr() net.Addr {
 	return s.ll.Addr()
 }
 
-func (s *Server) Run() error {
-	go s.runSyslog()
-	go s.runReplication()
-	go s.monitorDiscoverd()
+// Run starts the server's listeners and blocks until ctx is canceled, a
+// SIGINT/SIGTERM is received, or one of its members fails. On return, all
+// in-flight connections have been drained and the aggregator & replicator
+// have been flushed.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	eg, egctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		return runMember(egctx, member{
+			name: "syslog",
+			run:  s.runSyslogMember,
+			// only retry Accept errors that look transient (e.g. a
+			// momentarily exhausted fd table); anything else - a closed
+			// or otherwise broken listener - aborts the group instead of
+			// spinning forever against dead ingest
+			restartOn: isTransientAcceptErr,
+		})
+	})
+	eg.Go(func() error { return runMember(egctx, member{name: "api", run: s.runAPIMember}) })
+	eg.Go(func() error { return s.closeListenersOnDone(egctx) })
+
+	// drain in-flight syslog connections and flush the raft node &
+	// aggregator on every return path, not just the happy one - otherwise
+	// an early error return (e.g. discoverd registration failing below)
+	// leaves the Aggregator's run() goroutine and the raft node running
+	// forever
+	defer func() {
+		s.lwg.Wait()
+
+		if rErr := s.Replicator.Shutdown(); rErr != nil {
+			log15.Error("raft shutdown error", "err", rErr)
+		}
+		s.Aggregator.Shutdown()
+	}()
+
+	// the syslog & api members above are already running against listeners
+	// bound in NewServer, so registering the heartbeat here - rather than in
+	// NewServer - avoids discoverd routing traffic to this node before it's
+	// actually serving
+	hb, err := s.discd.AddServiceAndRegister(s.serviceName, s.ll.Addr().String())
+	if err != nil {
+		cancel()
+		eg.Wait()
+		return err
+	}
+	s.hb = hb
+
+	var signaled bool
+	eg.Go(func() error {
+		if sig := waitForSignal(egctx); sig != nil {
+			log15.Info("received signal, shutting down", "signal", sig)
+			signaled = true
+			cancel()
+		}
+		return nil
+	})
+
+	err = eg.Wait()
+
+	// close discoverd service heartbeater
+	if hbErr := s.hb.Close(); hbErr != nil {
+		log15.Error("heartbeat shutdown error", "err", hbErr)
+	}
+
+	if err != nil {
+		return err
+	}
+	if signaled {
+		return nil
+	}
+	return ctx.Err()
+}
 
-	return http.Serve(s.al, s.api)
+// isTransientAcceptErr reports whether err, returned from s.ll.Accept(),
+// looks like a transient condition (e.g. a momentarily exhausted fd table)
+// worth retrying, rather than a fatal listener failure that should abort
+// the syslog member instead of retrying forever.
+func isTransientAcceptErr(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Temporary()
 }
 
-func (s *Server) runSyslog() {
+// runSyslogMember runs the syslog accept loop until closeListenersOnDone
+// closes s.ll. An Accept error that isn't the result of that close is
+// returned: runMember restarts the member if isTransientAcceptErr reports it
+// transient, and otherwise it aborts the rest of the group.
+func (s *Server) runSyslogMember(ctx context.Context) error {
 	for {
 		conn, err := s.ll.Accept()
 		if err != nil {
-			return
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
 		}
 
 		s.lwg.Add(1)
 		go func(c net.Conn) {
 			defer s.lwg.Done()
-			s.drainSyslogConn(c)
+			s.drainSyslogConn(ctx, c)
 		}(conn)
 	}
 }
 
-func (s *Server) drainSyslogConn(conn net.Conn) {
+// runAPIMember runs the API HTTP server until closeListenersOnDone closes
+// s.al. A serve error that isn't the result of that close is treated as a
+// real failure and aborts the rest of the group.
+func (s *Server) runAPIMember(ctx context.Context) error {
+	err := http.Serve(s.al, s.api)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// closeListenersOnDone closes the syslog & api listeners as soon as ctx is
+// done, unblocking their Accept loops.
+func (s *Server) closeListenersOnDone(ctx context.Context) error {
+	<-ctx.Done()
+
+	if err := s.ll.Close(); err != nil {
+		log15.Error("syslog listener shutdown error", "err", err)
+	}
+	if err := s.al.Close(); err != nil {
+		log15.Error("api listener shutdown error", "err", err)
+	}
+	return nil
+}
+
+func (s *Server) drainSyslogConn(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
 
 	connDone := make(chan struct{})
@@ -184,7 +284,7 @@ func (s *Server) drainSyslogConn(conn net.Conn) {
 	go func() {
 		select {
 		case <-connDone:
-		case <-s.shutdown:
+		case <-ctx.Done():
 			conn.Close()
 		}
 	}()
@@ -197,113 +297,15 @@ func (s *Server) drainSyslogConn(conn net.Conn) {
 		msgCopy := make([]byte, len(msgBytes))
 		copy(msgCopy, msgBytes)
 
-		msg, err := rfc5424.Parse(msgCopy)
-		if err != nil {
+		if _, err := rfc5424.Parse(msgCopy); err != nil {
 			log15.Error("rfc5424 parse error", "err", err)
-		} else {
-			s.Aggregator.Feed(msg)
-			s.Replicator.Feed(msg)
-		}
-	}
-}
-
-func (s *Server) runReplication() {
-	for {
-		conn, err := s.rl.Accept()
-		if err != nil {
-			return
-		}
-
-		s.rwg.Add(1)
-		go func(c net.Conn) {
-			defer s.rwg.Done()
-			s.fillReplicationConn(c)
-		}(conn)
-	}
-}
-
-func (s *Server) fillReplicationConn(conn net.Conn) {
-	conn = connutil.CloseNotifyConn(conn)
-	defer conn.Close()
-
-	// pause the aggregator, shallow copy the aggregator's buffers, register a
-	// replication stream, then unpause the aggregator
-	unpause := s.Aggregator.Pause()
-	buffers := s.Aggregator.CopyBuffers()
-	msgc := s.Replicator.Register(conn.(connutil.CloseNotifier).CloseNotify())
-	unpause()
-
-	if err := snapshot.StreamTo(buffers, msgc, conn); err != nil {
-		log15.Error("replication error", "err", err)
-		go func() {
-			for range msgc {
-			}
-		}()
-	}
-}
-
-func (s *Server) monitorDiscoverd() {
-	var unfollowc chan struct{}
-
-	leader, err := s.srv.Leader()
-	if err != nil {
-		log15.Error("discoverd monitor error", "err", err)
-	}
-	if leader != nil {
-		if leader.Addr == s.hb.Addr() {
-			log15.Info("replication event", "status", "leader")
-			return
+			continue
 		}
-		if unfollowc, err = s.follow(leader.Addr); err != nil {
-			log15.Error("replication error", "err", err)
-		}
-	}
-
-	for event := range s.eventc {
-		switch event.Kind {
-		case discoverd.EventKindLeader:
-			if unfollowc != nil {
-				close(unfollowc)
-			}
 
-			leader = event.Instance
-			if leader.Addr != s.hb.Addr() {
-				if unfollowc, err = s.follow(leader.Addr); err != nil {
-					log15.Error("replication error", "err", err)
-				} else {
-					log15.Info("replication event", "status", "follower", "leader", leader.Addr)
-				}
-			} else {
-				log15.Info("replication event", "status", "leader")
-				return
-			}
+		// propose the message as a Raft log entry; it only reaches this or
+		// any other peer's Aggregator once committed, via raftFSM.Apply
+		if err := s.Replicator.Feed(msgCopy); err != nil {
+			log15.Error("raft apply error", "err", err)
 		}
 	}
 }
-
-func (s *Server) follow(addr string) (chan struct{}, error) {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-
-	s.Aggregator.Flush()
-
-	unfollowc := make(chan struct{})
-	go func() {
-		defer conn.Close()
-		sc := snapshot.NewScanner(conn)
-
-		for sc.Scan() {
-			select {
-			case <-unfollowc:
-				return
-			default:
-			}
-
-			s.Aggregator.Feed(sc.Message)
-		}
-	}()
-
-	return unfollowc, nil
-}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeNetErr is a minimal net.Error for exercising isTransientAcceptErr
+// without a real socket error.
+type fakeNetErr struct {
+	temporary bool
+}
+
+func (e *fakeNetErr) Error() string   { return "fake net error" }
+func (e *fakeNetErr) Timeout() bool   { return false }
+func (e *fakeNetErr) Temporary() bool { return e.temporary }
+
+func TestIsTransientAcceptErr(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"temporary net.Error", &fakeNetErr{temporary: true}, true},
+		{"non-temporary net.Error", &fakeNetErr{temporary: false}, false},
+		{"plain error", errors.New("boom"), false},
+	}
+
+	for _, c := range cases {
+		if got := isTransientAcceptErr(c.err); got != c.want {
+			t.Errorf("%s: isTransientAcceptErr() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package sinks
+
+import (
+	"io"
+	"os"
+
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+)
+
+// ConsoleSink writes messages to an io.Writer, typically os.Stdout or
+// os.Stderr.
+type ConsoleSink struct {
+	out io.Writer
+}
+
+// NewConsoleSink returns a ConsoleSink that writes to out.
+func NewConsoleSink(out io.Writer) *ConsoleSink {
+	return &ConsoleSink{out: out}
+}
+
+// NewStdoutSink returns a ConsoleSink that writes to os.Stdout.
+func NewStdoutSink() *ConsoleSink {
+	return NewConsoleSink(os.Stdout)
+}
+
+// NewStderrSink returns a ConsoleSink that writes to os.Stderr.
+func NewStderrSink() *ConsoleSink {
+	return NewConsoleSink(os.Stderr)
+}
+
+func (s *ConsoleSink) Write(msg *rfc5424.Message) error {
+	_, err := s.out.Write(encode(msg))
+	return err
+}
+
+// Close is a no-op; ConsoleSink does not own its underlying writer.
+func (s *ConsoleSink) Close() error { return nil }
@@ -0,0 +1,51 @@
+package sinks
+
+import (
+	"io"
+
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileConfig configures a FileSink.
+type FileConfig struct {
+	// Path is the log file to write to.
+	Path string
+	// MaxSize is the maximum size in megabytes of the log file before it
+	// gets rotated.
+	MaxSize int
+	// MaxAge is the maximum number of days to retain old, rotated log
+	// files.
+	MaxAge int
+	// MaxBackups is the maximum number of old, rotated log files to
+	// retain.
+	MaxBackups int
+}
+
+// FileSink writes messages to a file on disk, rotating it according to
+// FileConfig.
+type FileSink struct {
+	out io.WriteCloser
+}
+
+// NewFileSink returns a FileSink that writes to conf.Path.
+func NewFileSink(conf FileConfig) *FileSink {
+	return &FileSink{
+		out: &lumberjack.Logger{
+			Filename:   conf.Path,
+			MaxSize:    conf.MaxSize,
+			MaxAge:     conf.MaxAge,
+			MaxBackups: conf.MaxBackups,
+		},
+	}
+}
+
+func (s *FileSink) Write(msg *rfc5424.Message) error {
+	_, err := s.out.Write(encode(msg))
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.out.Close()
+}
@@ -0,0 +1,160 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	defaultHTTPBatchSize     = 100
+	defaultHTTPMaxRetries    = 5
+	defaultHTTPBackoff       = 500 * time.Millisecond
+	defaultHTTPFlushInterval = 5 * time.Second
+)
+
+// HTTPConfig configures an HTTPSink.
+type HTTPConfig struct {
+	// URL is the endpoint batches of messages are POSTed to as JSON.
+	URL string
+	// BatchSize is the number of messages buffered before a batch is
+	// sent. Defaults to 100.
+	BatchSize int
+	// FlushInterval bounds how long a partial batch (fewer than
+	// BatchSize messages) sits buffered before being sent anyway.
+	// Defaults to 5s.
+	FlushInterval time.Duration
+	// MaxRetries is the number of attempts made to deliver a batch before
+	// giving up. Defaults to 5.
+	MaxRetries int
+	// Client is the http.Client used to deliver batches. Defaults to
+	// http.DefaultClient.
+	Client *http.Client
+}
+
+// HTTPSink batches messages and POSTs them as JSON to a configured URL,
+// flushing early on a timer so a low-volume stream still gets delivered
+// promptly, and retrying with exponential backoff when a request fails.
+type HTTPSink struct {
+	conf HTTPConfig
+
+	mu    sync.Mutex
+	batch []*rfc5424.Message
+
+	closec chan struct{}
+	donec  chan struct{}
+}
+
+// NewHTTPSink returns an HTTPSink that posts batches of size
+// conf.BatchSize, or whatever has accumulated every conf.FlushInterval, to
+// conf.URL.
+func NewHTTPSink(conf HTTPConfig) *HTTPSink {
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultHTTPBatchSize
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = defaultHTTPFlushInterval
+	}
+	if conf.MaxRetries <= 0 {
+		conf.MaxRetries = defaultHTTPMaxRetries
+	}
+	if conf.Client == nil {
+		conf.Client = http.DefaultClient
+	}
+
+	s := &HTTPSink{
+		conf:   conf,
+		closec: make(chan struct{}),
+		donec:  make(chan struct{}),
+	}
+	go s.flushLoop()
+	return s
+}
+
+func (s *HTTPSink) flushLoop() {
+	defer close(s.donec)
+
+	t := time.NewTicker(s.conf.FlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := s.flush(); err != nil {
+				log15.Error("http sink flush error", "err", err)
+			}
+		case <-s.closec:
+			return
+		}
+	}
+}
+
+func (s *HTTPSink) Write(msg *rfc5424.Message) error {
+	s.mu.Lock()
+	s.batch = append(s.batch, msg)
+	full := len(s.batch) >= s.conf.BatchSize
+	s.mu.Unlock()
+
+	if !full {
+		return nil
+	}
+	return s.flush()
+}
+
+// flush posts whatever is currently buffered, if anything.
+func (s *HTTPSink) flush() error {
+	s.mu.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.post(batch)
+}
+
+// Close stops the flush timer and flushes any partial batch still
+// buffered.
+func (s *HTTPSink) Close() error {
+	close(s.closec)
+	<-s.donec
+
+	return s.flush()
+}
+
+func (s *HTTPSink) post(batch []*rfc5424.Message) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	backoff := defaultHTTPBackoff
+	for attempt := 0; attempt < s.conf.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		res, err := s.conf.Client.Post(s.conf.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode >= 300 {
+			lastErr = fmt.Errorf("sinks: http sink got status %d from %s", res.StatusCode, s.conf.URL)
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
@@ -0,0 +1,19 @@
+// Package sinks provides pluggable destinations for aggregated syslog
+// messages, in addition to the in-memory ring buffers kept by Aggregator.
+package sinks
+
+import "github.com/flynn/flynn/pkg/syslog/rfc5424"
+
+// Sink receives a stream of syslog messages fed to an Aggregator.
+// Implementations must be safe for use by a single goroutine at a time; the
+// Aggregator serializes calls to Write and Close per sink.
+type Sink interface {
+	Write(msg *rfc5424.Message) error
+	Close() error
+}
+
+// encode renders msg in its wire form, newline-terminated, for sinks that
+// write a plain log stream rather than structured records.
+func encode(msg *rfc5424.Message) []byte {
+	return append([]byte(msg.String()), '\n')
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/flynn/flynn/logaggregator/sinks"
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+
+	"github.com/flynn/flynn/Godeps/_workspace/src/gopkg.in/inconshreveable/log15.v2"
+)
+
+// sinkQueueSize bounds how many messages a sink can lag behind the ingest
+// goroutine before further messages are dropped for it.
+const sinkQueueSize = 1000
+
+// sinkWorker feeds a single sinks.Sink from a bounded queue on its own
+// goroutine, so that a slow or blocked sink can't back-pressure the syslog
+// ingest goroutine.
+type sinkWorker struct {
+	sink  sinks.Sink
+	msgc  chan *rfc5424.Message
+	donec chan struct{}
+	drops uint64 // atomic
+	errs  uint64 // atomic
+}
+
+func newSinkWorker(sink sinks.Sink) *sinkWorker {
+	w := &sinkWorker{
+		sink:  sink,
+		msgc:  make(chan *rfc5424.Message, sinkQueueSize),
+		donec: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *sinkWorker) run() {
+	defer close(w.donec)
+
+	for msg := range w.msgc {
+		if err := w.sink.Write(msg); err != nil {
+			atomic.AddUint64(&w.errs, 1)
+			log15.Error("sink write error", "err", err)
+		}
+	}
+}
+
+// feed queues msg for the sink, dropping it if the queue is full.
+func (w *sinkWorker) feed(msg *rfc5424.Message) {
+	select {
+	case w.msgc <- msg:
+	default:
+		atomic.AddUint64(&w.drops, 1)
+		log15.Error("sink queue full, dropping message")
+	}
+}
+
+// Drops returns the number of messages dropped for this sink so far.
+func (w *sinkWorker) Drops() uint64 {
+	return atomic.LoadUint64(&w.drops)
+}
+
+// Errors returns the number of Write calls that returned an error for this
+// sink so far.
+func (w *sinkWorker) Errors() uint64 {
+	return atomic.LoadUint64(&w.errs)
+}
+
+// close stops feeding the sink and closes it. It waits for run to drain
+// and exit first, so Write and Close are never called concurrently.
+func (w *sinkWorker) close() error {
+	close(w.msgc)
+	<-w.donec
+	return w.sink.Close()
+}
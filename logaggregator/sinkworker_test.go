@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flynn/flynn/pkg/syslog/rfc5424"
+)
+
+// fakeSink records every message written to it and blocks in Write until
+// unblock is closed, so tests can deterministically fill a sinkWorker's
+// queue.
+type fakeSink struct {
+	mu      sync.Mutex
+	written []*rfc5424.Message
+	closed  bool
+
+	unblock  chan struct{}
+	writeErr error
+
+	started chan struct{} // closed when Write is called for the first time
+}
+
+func newErrFakeSink(err error) *fakeSink {
+	s := newFakeSink()
+	close(s.unblock) // let Write return immediately
+	s.writeErr = err
+	return s
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{
+		unblock: make(chan struct{}),
+		started: make(chan struct{}),
+	}
+}
+
+func (s *fakeSink) Write(msg *rfc5424.Message) error {
+	select {
+	case <-s.started:
+	default:
+		close(s.started)
+	}
+
+	<-s.unblock
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, msg)
+	return s.writeErr
+}
+
+func (s *fakeSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *fakeSink) messages() []*rfc5424.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*rfc5424.Message(nil), s.written...)
+}
+
+func testMessage(t *testing.T) *rfc5424.Message {
+	t.Helper()
+	msg, err := rfc5424.Parse([]byte("<174>1 2016-01-15T00:00:00Z host app 1 - - test message"))
+	if err != nil {
+		t.Fatalf("rfc5424.Parse: %v", err)
+	}
+	return msg
+}
+
+func TestSinkWorkerFeedAndClose(t *testing.T) {
+	sink := newFakeSink()
+	close(sink.unblock) // let Write return immediately
+
+	w := newSinkWorker(sink)
+	msg := testMessage(t)
+	w.feed(msg)
+
+	if err := w.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	got := sink.messages()
+	if len(got) != 1 || got[0] != msg {
+		t.Fatalf("sink received %v, want [%v]", got, msg)
+	}
+	if !sink.closed {
+		t.Fatal("sink was not closed")
+	}
+	if drops := w.Drops(); drops != 0 {
+		t.Fatalf("Drops() = %d, want 0", drops)
+	}
+}
+
+func TestSinkWorkerDropsWhenQueueFull(t *testing.T) {
+	sink := newFakeSink() // unblock never closed: Write never returns
+
+	w := newSinkWorker(sink)
+	msg := testMessage(t)
+
+	// feed the first message and wait for run() to dequeue it into Write -
+	// only then is the queue empty and guaranteed to stay that way (Write
+	// never returns), so filling it to capacity below is deterministic
+	// instead of racing run()'s goroutine
+	w.feed(msg)
+	select {
+	case <-sink.started:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for run() to start the first Write")
+	}
+
+	for i := 0; i < sinkQueueSize; i++ {
+		w.feed(msg)
+	}
+	// the queue is full and run() is blocked in Write on the first message,
+	// so every further feed should be dropped rather than blocking the caller
+	for i := 0; i < 5; i++ {
+		w.feed(msg)
+	}
+
+	if drops := w.Drops(); drops != 5 {
+		t.Fatalf("Drops() = %d, want 5", drops)
+	}
+
+	close(sink.unblock)
+	if err := w.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+func TestSinkWorkerCountsWriteErrors(t *testing.T) {
+	sink := newErrFakeSink(errors.New("write failed"))
+
+	w := newSinkWorker(sink)
+	msg := testMessage(t)
+	w.feed(msg)
+	w.feed(msg)
+
+	if err := w.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if errs := w.Errors(); errs != 2 {
+		t.Fatalf("Errors() = %d, want 2", errs)
+	}
+	if drops := w.Drops(); drops != 0 {
+		t.Fatalf("Drops() = %d, want 0", drops)
+	}
+}
+
+func TestSinkWorkerCloseWaitsForRunToDrain(t *testing.T) {
+	sink := newFakeSink()
+	w := newSinkWorker(sink)
+	msg := testMessage(t)
+	w.feed(msg)
+
+	closeErrc := make(chan error, 1)
+	go func() { closeErrc <- w.close() }()
+
+	select {
+	case <-closeErrc:
+		t.Fatal("close returned before run drained the queued message")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(sink.unblock)
+
+	select {
+	case err := <-closeErrc:
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("close did not return after sink unblocked")
+	}
+
+	if len(sink.messages()) != 1 {
+		t.Fatalf("sink received %d messages, want 1", len(sink.messages()))
+	}
+}